@@ -1,5 +1,4 @@
 /*
-
 Package envflag parses command-line flags defined by package flag from the environment.
 
 Usage:
@@ -33,21 +32,25 @@ An environment variable will not override a flag, but will override its default
 Flag names are automatically converted to environment variable keys according to
 the following rules:
 
-	- Non-ASCII runes are omitted.
-	- Uppercase letters, digits, and underscores are preserved.
-	- Lowercase letters are changed to uppercase.
-	- Hyphens are changed to underscores.
-	- All other runes are omitted.
-	- Prepend an underscore if a variable name would otherwise begin with a digit.
-
+  - Non-ASCII runes are omitted.
+  - Uppercase letters, digits, and underscores are preserved.
+  - Lowercase letters are changed to uppercase.
+  - Hyphens are changed to underscores.
+  - All other runes are omitted.
+  - Prepend an underscore if a variable name would otherwise begin with a digit.
 */
 package envflag
 
 import (
-	"strings"
+	"bufio"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"reflect"
+	"sort"
+	"strings"
 )
 
 // A VarSet wraps a flag.FlagSet. The zero value of a VarSet should not be used.
@@ -57,11 +60,20 @@ import (
 // flag.FlagSet with a VarSet to connect it to the environment.
 type VarSet struct {
 	// LookupEnv is an optional replacement for os.LookupEnv in this VarSet.
+	// If set, it takes precedence over the environment snapshot set by
+	// SetEnv or NewVarSetWithEnv.
 	LookupEnv func(key string) (string, bool)
 
-	fs            *flag.FlagSet
-	prefix        string
-	renames       map[string]string
+	fs         *flag.FlagSet
+	prefix     string
+	renames    map[string]string
+	envFile    map[string]string
+	sources    []ConfigSource
+	strict     bool
+	unknownEnv []string
+	required   []string
+	onSet      map[string]func(string) error
+	env        map[string]string
 }
 
 // NewVarSet creates a new VarSet with the specified flag set and error handling property.
@@ -69,6 +81,44 @@ func NewVarSet(fs *flag.FlagSet) *VarSet {
 	return &VarSet{fs: fs}
 }
 
+// NewVarSetWithEnv creates a new VarSet with the specified flag set, backed
+// by env instead of a lazily taken snapshot of os.Environ. env serves both
+// lookups and enumeration (e.g. for StrictPrefix), which makes it useful for
+// deterministic tests and for embedded uses that should not race against
+// os.Setenv.
+func NewVarSetWithEnv(fs *flag.FlagSet, env map[string]string) *VarSet {
+	return &VarSet{fs: fs, env: env}
+}
+
+// SetEnv replaces the environment snapshot this VarSet consults for both
+// lookups and enumeration.
+func (vs *VarSet) SetEnv(env map[string]string) {
+	vs.env = env
+}
+
+// Returns the environment snapshot this VarSet consults, taking one from
+// os.Environ the first time it's needed if none was supplied.
+func (vs *VarSet) resolvedEnv() map[string]string {
+	if vs.env == nil {
+		vs.env = environToMap(os.Environ())
+	}
+	return vs.env
+}
+
+// Splits each "KEY=VALUE" entry in environ into a map. Panics if an entry
+// has no '=', which should be impossible for a real os.Environ() result.
+func environToMap(environ []string) map[string]string {
+	m := make(map[string]string, len(environ))
+	for _, kv := range environ {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			panic(fmt.Sprintf("envflag: malformed environment entry %q", kv))
+		}
+		m[key] = value
+	}
+	return m
+}
+
 // SetPrefix specifies a string to prepend to all environment variable keys. An
 // underscore is automatically inserted between the prefix and variable key.
 func (vs *VarSet) SetPrefix(prefix string) {
@@ -84,8 +134,9 @@ func (vs *VarSet) RenameFlag(old, new string) {
 	vs.renames[old] = new
 }
 
-// Parse sets the value of flags that were not provided on the command-line but
-// are set in the environment.
+// Parse sets the value of flags that were not provided on the command-line,
+// consulting the environment and then, for flags still unset, any config
+// sources added with AddSource, in that order.
 func (vs *VarSet) Parse() error {
 	flags := make(map[string]*flag.Flag)
 	vs.fs.VisitAll(func(f *flag.Flag) {
@@ -96,31 +147,250 @@ func (vs *VarSet) Parse() error {
 	})
 	for _, f := range flags {
 		if err := vs.parseOne(f.Name, f.Value); err != nil {
-			switch vs.fs.ErrorHandling() {
-			case flag.ContinueOnError:
-				return err
-			case flag.ExitOnError:
-				os.Exit(2)
-			case flag.PanicOnError:
-				panic(err)
-			}
+			return vs.handleError(err)
 		}
 	}
+	if err := vs.checkRequired(); err != nil {
+		return vs.handleError(err)
+	}
+	if err := vs.runOnSet(); err != nil {
+		return vs.handleError(err)
+	}
+	if err := vs.checkUnknownEnv(); err != nil {
+		return vs.handleError(err)
+	}
 	return nil
 }
 
-// Retrieves the value of the environment variable associated with the specified
-// flag and, if the variable is set, stores its current value in dst.
+// Require marks flagName as mandatory: Parse fails if the flag's value is
+// still empty after the command line, the environment and any config
+// sources have been applied. A non-empty default value satisfies it.
+func (vs *VarSet) Require(flagName string) {
+	vs.required = append(vs.required, flagName)
+}
+
+// Requiref is a variant of Require that marks multiple flags as mandatory.
+func (vs *VarSet) Requiref(flagNames ...string) {
+	vs.required = append(vs.required, flagNames...)
+}
+
+// OnSet registers fn to run, after Parse has resolved flagName's value, to
+// validate it. Parse fails if fn returns an error.
+func (vs *VarSet) OnSet(flagName string, fn func(value string) error) {
+	if vs.onSet == nil {
+		vs.onSet = make(map[string]func(string) error)
+	}
+	vs.onSet[flagName] = fn
+}
+
+// MissingError reports the flags that Require or Requiref marked mandatory
+// but whose value was still empty once Parse had applied the command line,
+// the environment and any config sources.
+type MissingError struct {
+	Flags []string
+}
+
+func (e *MissingError) Error() string {
+	return fmt.Sprintf("missing required flag(s): %s", strings.Join(e.Flags, ", "))
+}
+
+// Checks that every flag named by Require or Requiref has a non-empty value.
+func (vs *VarSet) checkRequired() error {
+	var missing []string
+	for _, name := range vs.required {
+		if f := vs.fs.Lookup(name); f == nil || f.Value.String() == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	err := &MissingError{Flags: missing}
+	fmt.Fprintln(vs.fs.Output(), err)
+	vs.fs.Usage()
+	return err
+}
+
+// Runs the validation hooks registered with OnSet against each flag's
+// resolved value, in lexicographical order of flag name, stopping at the
+// first hook that fails.
+func (vs *VarSet) runOnSet() error {
+	var err error
+	vs.fs.VisitAll(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+		fn, ok := vs.onSet[f.Name]
+		if !ok {
+			return
+		}
+		value := f.Value.String()
+		if e := fn(value); e != nil {
+			err = vs.failf("invalid value %q for flag %q: %v", value, f.Name, e)
+		}
+	})
+	return err
+}
+
+// Applies the VarSet's error handling policy to err, as configured on the
+// wrapped FlagSet.
+func (vs *VarSet) handleError(err error) error {
+	switch vs.fs.ErrorHandling() {
+	case flag.ContinueOnError:
+		return err
+	case flag.ExitOnError:
+		os.Exit(2)
+	case flag.PanicOnError:
+		panic(err)
+	}
+	return nil
+}
+
+// Retrieves the value associated with the specified flag, from the
+// environment or, failing that, a config source, and if found stores it in
+// dst.
 func (vs *VarSet) parseOne(flagName string, dst flag.Value) error {
-	key := rewrite(vs.prefix, vs.renamed(flagName))
+	name := vs.renamed(flagName)
+	key := rewrite(vs.prefix, name)
 	if value, found := vs.lookupEnv(key); found {
 		if err := dst.Set(value); err != nil {
 			return vs.failf("invalid value %q for environment variable %q: %v", value, key, err)
 		}
+		return nil
+	}
+	if value, found := vs.lookupSource(name); found {
+		if err := dst.Set(value); err != nil {
+			return vs.failf("invalid value %q for flag %q from config source: %v", value, name, err)
+		}
+	}
+	return nil
+}
+
+// StrictPrefix controls whether Parse rejects unrecognized environment
+// variables that start with the configured prefix. This catches typos and
+// stale keys from renamed or removed flags. When strict is false (the
+// default), unrecognized keys are instead collected and made available
+// through UnknownEnv.
+//
+// StrictPrefix and UnknownEnv require enumerating the environment, which
+// vs.LookupEnv cannot do; if vs.LookupEnv is set, they find nothing. Use
+// SetEnv or NewVarSetWithEnv instead of vs.LookupEnv in a VarSet that relies
+// on StrictPrefix or UnknownEnv.
+func (vs *VarSet) StrictPrefix(strict bool) {
+	vs.strict = strict
+}
+
+// UnknownEnv returns the prefixed environment variables observed by the most
+// recent call to Parse that did not correspond to any registered flag. See
+// the StrictPrefix doc comment for the vs.LookupEnv caveat.
+func (vs *VarSet) UnknownEnv() []string {
+	return vs.unknownEnv
+}
+
+// Scans the environment for keys starting with the configured prefix that do
+// not match any registered flag, recording them in vs.unknownEnv. In strict
+// mode, it returns an error listing the offenders.
+func (vs *VarSet) checkUnknownEnv() error {
+	vs.unknownEnv = nil
+	// vs.LookupEnv takes precedence for value resolution in lookupEnv, but it
+	// has no way to enumerate the environment it represents; scanning
+	// resolvedEnv() here instead would report keys from an unrelated
+	// environment. Skip the scan rather than produce inconsistent results.
+	if vs.LookupEnv != nil {
+		return nil
+	}
+	prefix := vs.envPrefix()
+	if prefix == "" {
+		return nil
+	}
+	known := make(map[string]bool)
+	vs.fs.VisitAll(func(f *flag.Flag) {
+		known[rewrite(vs.prefix, vs.renamed(f.Name))] = true
+	})
+	for key := range vs.resolvedEnv() {
+		if !strings.HasPrefix(key, prefix) || known[key] {
+			continue
+		}
+		vs.unknownEnv = append(vs.unknownEnv, key)
+	}
+	sort.Strings(vs.unknownEnv)
+	if vs.strict && len(vs.unknownEnv) > 0 {
+		return vs.failf("unknown environment variable(s): %s", strings.Join(vs.unknownEnv, ", "))
 	}
 	return nil
 }
 
+// Reports the rewritten form of the configured prefix, as it appears at the
+// start of every environment key Parse looks at. Returns "" if no prefix is
+// configured.
+func (vs *VarSet) envPrefix() string {
+	if vs.prefix == "" {
+		return ""
+	}
+	var b strings.Builder
+	rewriteInto(&b, vs.prefix)
+	b.WriteByte('_')
+	return b.String()
+}
+
+// Consults the config sources added with AddSource, in order, returning the
+// first value found.
+func (vs *VarSet) lookupSource(name string) (string, bool) {
+	for _, src := range vs.sources {
+		if value, found := src.Lookup(name); found {
+			return value, found
+		}
+	}
+	return "", false
+}
+
+// A ConfigSource supplies flag values from a configuration layer consulted by
+// Parse after the command line and the environment, and before flag
+// defaults.
+type ConfigSource interface {
+	// Lookup returns the configured value for the named flag, if any.
+	Lookup(flagName string) (string, bool)
+}
+
+// AddSource appends src to the ordered list of config sources Parse consults
+// for flags not already set by the command line or the environment. Sources
+// are tried in the order they were added; the first to report a value wins.
+func (vs *VarSet) AddSource(src ConfigSource) {
+	vs.sources = append(vs.sources, src)
+}
+
+// jsonSource is a ConfigSource backed by a decoded JSON object.
+type jsonSource struct {
+	values map[string]interface{}
+}
+
+// NewJSONSource creates a ConfigSource from a JSON object read from r. Each
+// flag is matched against the object's keys case-insensitively, with hyphens
+// and underscores treated as equivalent; matching values are converted to
+// strings with fmt.Sprint.
+func NewJSONSource(r io.Reader) (ConfigSource, error) {
+	var values map[string]interface{}
+	if err := json.NewDecoder(r).Decode(&values); err != nil {
+		return nil, err
+	}
+	return &jsonSource{values: values}, nil
+}
+
+func (s *jsonSource) Lookup(flagName string) (string, bool) {
+	want := normalizeConfigKey(flagName)
+	for key, value := range s.values {
+		if normalizeConfigKey(key) == want {
+			return fmt.Sprint(value), true
+		}
+	}
+	return "", false
+}
+
+// Normalizes a flag or config key for case/hyphen-insensitive matching.
+func normalizeConfigKey(s string) string {
+	return strings.ReplaceAll(strings.ToLower(s), "-", "_")
+}
+
 // If the flag was renamed by vs.Rename, reports its new name.
 func (vs *VarSet) renamed(flagName string) string {
 	if s := vs.renames[flagName]; s != "" {
@@ -129,12 +399,175 @@ func (vs *VarSet) renamed(flagName string) string {
 	return flagName
 }
 
-// Calls vs.LookupEnv, or os.LookupEnv if vs.LookupEnv is nil.
+// EnvKey returns the environment variable key that Parse consults for the
+// named flag, after applying the VarSet's prefix and any RenameFlag calls.
+func (vs *VarSet) EnvKey(flagName string) string {
+	return rewrite(vs.prefix, vs.renamed(flagName))
+}
+
+// FlagEnvHinter formats the environment variable annotation that
+// PrintDefaults appends to a flag's usage string. Replace it to customize
+// the annotation, e.g. to omit it for flags with no meaningful env key.
+var FlagEnvHinter = func(envKey, usage string) string {
+	return fmt.Sprintf("%s (env %s)", usage, envKey)
+}
+
+// PrintDefaults prints, to the wrapped FlagSet's output, a usage line for
+// every flag, each annotated with the environment variable that Parse
+// consults for it. The annotation is produced by FlagEnvHinter.
+func (vs *VarSet) PrintDefaults() {
+	vs.fs.VisitAll(func(f *flag.Flag) {
+		typeName, usage := flag.UnquoteUsage(f)
+		usage = FlagEnvHinter(vs.EnvKey(f.Name), usage)
+		line := "  -" + f.Name
+		if typeName != "" {
+			line += " " + typeName
+		}
+		line += "\n    \t" + usage
+		if !isZeroValue(f) {
+			if typeName == "string" {
+				line += fmt.Sprintf(" (default %q)", f.DefValue)
+			} else {
+				line += fmt.Sprintf(" (default %s)", f.DefValue)
+			}
+		}
+		fmt.Fprintln(vs.fs.Output(), line)
+	})
+}
+
+// Reports whether f's default value is its type's zero value, mirroring the
+// suppression flag.FlagSet.PrintDefaults applies so that, e.g., a bool flag
+// defaulting to false or an int flag defaulting to 0 gets no "(default ...)"
+// clause.
+func isZeroValue(f *flag.Flag) bool {
+	typ := reflect.TypeOf(f.Value)
+	var zero reflect.Value
+	if typ.Kind() == reflect.Ptr {
+		zero = reflect.New(typ.Elem())
+	} else {
+		zero = reflect.Zero(typ)
+	}
+	return f.DefValue == zero.Interface().(flag.Value).String()
+}
+
+// InstallUsage replaces the wrapped FlagSet's Usage function with one that
+// calls PrintDefaults, so "-h" output includes each flag's environment
+// variable.
+func (vs *VarSet) InstallUsage() {
+	vs.fs.Usage = func() {
+		fmt.Fprintf(vs.fs.Output(), "Usage of %s:\n", vs.fs.Name())
+		vs.PrintDefaults()
+	}
+}
+
+// Calls vs.LookupEnv if set; otherwise consults the environment snapshot
+// from SetEnv, NewVarSetWithEnv, or (lazily) os.Environ. Either way, falls
+// back to any entries loaded with ParseEnvFile or ParseEnvFiles.
 func (vs *VarSet) lookupEnv(key string) (string, bool) {
 	if vs.LookupEnv != nil {
-		return vs.LookupEnv(key)
+		if value, found := vs.LookupEnv(key); found {
+			return value, found
+		}
+	} else if value, found := vs.resolvedEnv()[key]; found {
+		return value, found
 	}
-	return os.LookupEnv(key)
+	if value, found := vs.envFile[key]; found {
+		return value, found
+	}
+	return "", false
+}
+
+// ParseEnvFile reads dotenv-formatted "KEY=VALUE" lines from r and adds them
+// as a fallback source for environment variables: they are consulted by
+// Parse only when a key is absent from the real environment, so a checked-in
+// defaults file can never override an operator's explicit setting. Blank
+// lines and lines beginning with '#' are ignored. A double-quoted value has
+// \n, \t, and \" escapes expanded; a single-quoted value is taken literally.
+func (vs *VarSet) ParseEnvFile(r io.Reader) error {
+	if vs.envFile == nil {
+		vs.envFile = make(map[string]string)
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := splitEnvLine(line)
+		if !ok {
+			continue
+		}
+		vs.envFile[key] = value
+	}
+	return scanner.Err()
+}
+
+// ParseEnvFiles is a convenience wrapper around ParseEnvFile that opens and
+// reads each named file in turn; later files take precedence over earlier
+// ones.
+func (vs *VarSet) ParseEnvFiles(paths ...string) error {
+	for _, path := range paths {
+		if err := vs.parseEnvFile(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (vs *VarSet) parseEnvFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return vs.ParseEnvFile(f)
+}
+
+// Splits a dotenv line into a key and value, unquoting and (for
+// double-quoted values) expanding escape sequences in the value.
+func splitEnvLine(line string) (key, value string, ok bool) {
+	i := strings.IndexByte(line, '=')
+	if i < 0 {
+		return "", "", false
+	}
+	key = strings.TrimSpace(line[:i])
+	if key == "" {
+		return "", "", false
+	}
+	value = strings.TrimSpace(line[i+1:])
+	switch {
+	case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+		value = expandEscapes(value[1 : len(value)-1])
+	case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+		value = value[1 : len(value)-1]
+	}
+	return key, value, true
+}
+
+// Expands \n, \t, and \" escape sequences in a double-quoted dotenv value.
+func expandEscapes(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case 'n':
+				b.WriteByte('\n')
+				i++
+				continue
+			case 't':
+				b.WriteByte('\t')
+				i++
+				continue
+			case '"':
+				b.WriteByte('"')
+				i++
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
 }
 
 func (vs *VarSet) failf(format string, args ...interface{}) error {
@@ -155,18 +588,93 @@ func SetPrefix(prefix string) {
 	CommandLine.SetPrefix(prefix)
 }
 
+// SetEnv replaces the environment snapshot CommandLine consults for both
+// lookups and enumeration.
+func SetEnv(env map[string]string) {
+	CommandLine.SetEnv(env)
+}
+
 // RenameFlag modifies a flag name before it is converted to an environment key.
 // The new name will be transformed by the same process as any other flag name.
 func RenameFlag(old, new string) {
 	CommandLine.RenameFlag(old, new)
 }
 
+// ParseEnvFile reads dotenv-formatted "KEY=VALUE" lines from r and adds them
+// as a fallback source for environment variables consulted by Parse.
+func ParseEnvFile(r io.Reader) error {
+	return CommandLine.ParseEnvFile(r)
+}
+
+// ParseEnvFiles is a convenience wrapper around ParseEnvFile that opens and
+// reads each named file in turn; later files take precedence over earlier
+// ones.
+func ParseEnvFiles(paths ...string) error {
+	return CommandLine.ParseEnvFiles(paths...)
+}
+
 // Parse sets the value of flags that were not provided on the command-line but
 // are set in the environment.
 func Parse() {
 	_ = CommandLine.Parse() // default behavior is ExitOnError
 }
 
+// EnvKey returns the environment variable key that Parse consults for the
+// named flag, after applying the configured prefix and any RenameFlag calls.
+func EnvKey(flagName string) string {
+	return CommandLine.EnvKey(flagName)
+}
+
+// PrintDefaults prints, to flag.CommandLine's output, a usage line for every
+// flag, each annotated with the environment variable that Parse consults for
+// it.
+func PrintDefaults() {
+	CommandLine.PrintDefaults()
+}
+
+// InstallUsage replaces flag.CommandLine's Usage function with one that calls
+// PrintDefaults, so "-h" output includes each flag's environment variable.
+// The flag.Usage variable itself is left unchanged.
+func InstallUsage() {
+	CommandLine.InstallUsage()
+}
+
+// AddSource appends src to the ordered list of config sources Parse consults
+// for flags not already set by the command line or the environment.
+func AddSource(src ConfigSource) {
+	CommandLine.AddSource(src)
+}
+
+// StrictPrefix controls whether Parse rejects unrecognized environment
+// variables that start with the configured prefix.
+func StrictPrefix(strict bool) {
+	CommandLine.StrictPrefix(strict)
+}
+
+// UnknownEnv returns the prefixed environment variables observed by the most
+// recent call to Parse that did not correspond to any registered flag.
+func UnknownEnv() []string {
+	return CommandLine.UnknownEnv()
+}
+
+// Require marks flagName as mandatory: Parse fails if the flag's value is
+// still empty after the command line, the environment and any config
+// sources have been applied. A non-empty default value satisfies it.
+func Require(flagName string) {
+	CommandLine.Require(flagName)
+}
+
+// Requiref is a variant of Require that marks multiple flags as mandatory.
+func Requiref(flagNames ...string) {
+	CommandLine.Requiref(flagNames...)
+}
+
+// OnSet registers fn to run, after Parse has resolved flagName's value, to
+// validate it. Parse fails if fn returns an error.
+func OnSet(flagName string, fn func(value string) error) {
+	CommandLine.OnSet(flagName, fn)
+}
+
 // Transforms a flag name, plus an optional prefix, into an environment key.
 func rewrite(prefix, name string) string {
 	b := strings.Builder{}
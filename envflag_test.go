@@ -3,7 +3,10 @@ package envflag
 import (
 	"flag"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -118,6 +121,305 @@ func TestRewrite(t *testing.T) {
 	}
 }
 
+// Verifies that values from ParseEnvFile are used as a fallback, and that
+// the real environment still takes precedence.
+func TestParseEnvFile(t *testing.T) {
+	const dotenv = `
+# a comment
+FOO=from file
+
+BAR="escaped\nvalue"
+QUX='literal\nvalue'
+`
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	foo := fs.String("foo", "", "")
+	bar := fs.String("bar", "", "")
+	qux := fs.String("qux", "", "")
+	must(fs.Parse(nil))
+
+	vs := NewVarSet(fs)
+	vs.LookupEnv = func(key string) (string, bool) {
+		if key == "FOO" {
+			return "from environment", true
+		}
+		return "", false
+	}
+	must(vs.ParseEnvFile(strings.NewReader(dotenv)))
+	must(vs.Parse())
+
+	if *foo != "from environment" {
+		t.Errorf("flag -foo is %q, want %q", *foo, "from environment")
+	}
+	if *bar != "escaped\nvalue" {
+		t.Errorf("flag -bar is %q, want %q", *bar, "escaped\nvalue")
+	}
+	if *qux != `literal\nvalue` {
+		t.Errorf(`flag -qux is %q, want %q`, *qux, `literal\nvalue`)
+	}
+}
+
+// Verifies that ParseEnvFiles reads multiple files in order, with later
+// files taking precedence over earlier ones.
+func TestParseEnvFiles(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.env")
+	override := filepath.Join(dir, "override.env")
+	must(os.WriteFile(base, []byte("FOO=base\nBAR=from base\n"), 0o644))
+	must(os.WriteFile(override, []byte("FOO=override\n"), 0o644))
+
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	foo := fs.String("foo", "", "")
+	bar := fs.String("bar", "", "")
+	must(fs.Parse(nil))
+
+	vs := NewVarSet(fs)
+	vs.LookupEnv = func(string) (string, bool) { return "", false }
+	must(vs.ParseEnvFiles(base, override))
+	must(vs.Parse())
+
+	if *foo != "override" {
+		t.Errorf("flag -foo is %q, want %q", *foo, "override")
+	}
+	if *bar != "from base" {
+		t.Errorf("flag -bar is %q, want %q", *bar, "from base")
+	}
+}
+
+// Verifies that EnvKey and PrintDefaults annotate usage output with the
+// resolved environment variable for each flag.
+func TestPrintDefaults(t *testing.T) {
+	fs := flag.NewFlagSet("prog", flag.ContinueOnError)
+	fs.String("listen-addr", ":8080", "server listen address")
+	fs.Bool("verbose", false, "enable verbose logging")
+	fs.Int("retries", 0, "number of retries")
+	must(fs.Parse(nil))
+
+	vs := NewVarSet(fs)
+	vs.SetPrefix("myapp")
+
+	if key := vs.EnvKey("listen-addr"); key != "MYAPP_LISTEN_ADDR" {
+		t.Errorf("EnvKey(%q) = %q, want %q", "listen-addr", key, "MYAPP_LISTEN_ADDR")
+	}
+
+	var buf strings.Builder
+	fs.SetOutput(&buf)
+	vs.PrintDefaults()
+	out := buf.String()
+
+	if want := "(env MYAPP_LISTEN_ADDR)"; !strings.Contains(out, want) {
+		t.Errorf("PrintDefaults() output %q does not contain %q", out, want)
+	}
+	if want := `(default ":8080")`; !strings.Contains(out, want) {
+		t.Errorf("PrintDefaults() output %q does not contain %q", out, want)
+	}
+	for _, zero := range []string{"(default false)", "(default 0)"} {
+		if strings.Contains(out, zero) {
+			t.Errorf("PrintDefaults() output %q unexpectedly contains zero-value clause %q", out, zero)
+		}
+	}
+}
+
+// Verifies that a ConfigSource is consulted only for flags left unset by the
+// environment, and that NewJSONSource matches flag names case- and
+// hyphen/underscore-insensitively.
+func TestAddSource(t *testing.T) {
+	const jsonConfig = `{"Listen-Addr": ":9090", "other_flag": 42, "env_flag": "from config"}`
+
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	listenAddr := fs.String("listen-addr", ":8080", "")
+	otherFlag := fs.Int("other-flag", 0, "")
+	envFlag := fs.String("env-flag", "", "")
+	must(fs.Parse(nil))
+
+	src, err := NewJSONSource(strings.NewReader(jsonConfig))
+	must(err)
+
+	vs := NewVarSet(fs)
+	vs.LookupEnv = func(key string) (string, bool) {
+		if key == "ENV_FLAG" {
+			return "from environment", true
+		}
+		return "", false
+	}
+	vs.AddSource(src)
+	must(vs.Parse())
+
+	if *listenAddr != ":9090" {
+		t.Errorf("flag -listen-addr is %q, want %q", *listenAddr, ":9090")
+	}
+	if *otherFlag != 42 {
+		t.Errorf("flag -other-flag is %d, want %d", *otherFlag, 42)
+	}
+	if *envFlag != "from environment" {
+		t.Errorf("flag -env-flag is %q, want %q", *envFlag, "from environment")
+	}
+}
+
+// Verifies that unrecognized prefixed environment variables are reported
+// through UnknownEnv in non-strict mode, and rejected by Parse in strict
+// mode.
+func TestStrictPrefix(t *testing.T) {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.String("listen-addr", "", "")
+	must(fs.Parse(nil))
+
+	env := map[string]string{
+		"MYAPP_LISTEN_ADDR": ":9090",
+		"MYAPP_LSITEN_ADDR": ":9090", // typo
+		"OTHER_APP_FOO":     "bar",
+	}
+
+	t.Run("non-strict", func(t *testing.T) {
+		vs := NewVarSetWithEnv(fs, env)
+		vs.SetPrefix("myapp")
+		must(vs.Parse())
+		if got, want := vs.UnknownEnv(), []string{"MYAPP_LSITEN_ADDR"}; !equalStrings(got, want) {
+			t.Errorf("UnknownEnv() = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		vs := NewVarSetWithEnv(fs, env)
+		vs.SetPrefix("myapp")
+		vs.StrictPrefix(true)
+		if err := vs.Parse(); err == nil {
+			t.Error("Parse() = nil, want error for unknown environment variable")
+		}
+	})
+}
+
+// Verifies that NewVarSetWithEnv and SetEnv back lookups, and that LookupEnv
+// still takes precedence when set.
+func TestSetEnv(t *testing.T) {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	value := fs.String("foo", "", "")
+	must(fs.Parse(nil))
+
+	vs := NewVarSetWithEnv(fs, map[string]string{"FOO": "from map"})
+	must(vs.Parse())
+	if *value != "from map" {
+		t.Errorf("flag -foo is %q, want %q", *value, "from map")
+	}
+
+	fs2 := flag.NewFlagSet("", flag.ContinueOnError)
+	value2 := fs2.String("foo", "", "")
+	must(fs2.Parse(nil))
+	vs2 := NewVarSet(fs2)
+	vs2.SetEnv(map[string]string{"FOO": "from map"})
+	vs2.LookupEnv = func(key string) (string, bool) {
+		if key == "FOO" {
+			return "from LookupEnv", true
+		}
+		return "", false
+	}
+	must(vs2.Parse())
+	if *value2 != "from LookupEnv" {
+		t.Errorf("flag -foo is %q, want %q", *value2, "from LookupEnv")
+	}
+}
+
+// Verifies that setting LookupEnv disables the StrictPrefix/UnknownEnv scan
+// rather than enumerating an unrelated os.Environ.
+func TestStrictPrefixWithLookupEnv(t *testing.T) {
+	const leftoverKey = "ENVFLAGTEST_UNRELATED_LEFTOVER"
+	os.Setenv(leftoverKey, "x")
+	defer os.Unsetenv(leftoverKey)
+
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.String("listen-addr", "", "")
+	must(fs.Parse(nil))
+
+	vs := NewVarSet(fs)
+	vs.SetPrefix("envflagtest")
+	vs.LookupEnv = func(key string) (string, bool) {
+		if key == "ENVFLAGTEST_LISTEN_ADDR" {
+			return ":9090", true
+		}
+		return "", false
+	}
+	vs.StrictPrefix(true)
+	if err := vs.Parse(); err != nil {
+		t.Errorf("Parse() = %v, want nil", err)
+	}
+	if got := vs.UnknownEnv(); len(got) != 0 {
+		t.Errorf("UnknownEnv() = %v, want empty", got)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Verifies that Require reports every unsatisfied flag via a MissingError.
+func TestRequire(t *testing.T) {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.String("database-url", "", "")
+	fs.String("api-key", "", "")
+	must(fs.Parse(nil))
+
+	vs := NewVarSet(fs)
+	vs.LookupEnv = func(string) (string, bool) { return "", false }
+	vs.Requiref("database-url", "api-key")
+
+	err := vs.Parse()
+	missing, ok := err.(*MissingError)
+	if !ok {
+		t.Fatalf("Parse() returned %T, want *MissingError", err)
+	}
+	want := []string{"database-url", "api-key"}
+	if !equalStrings(missing.Flags, want) {
+		t.Errorf("MissingError.Flags = %v, want %v", missing.Flags, want)
+	}
+}
+
+// Verifies that OnSet validation hooks run against the resolved value and can
+// fail Parse.
+func TestOnSet(t *testing.T) {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.String("log-level", "info", "")
+	must(fs.Parse(nil))
+
+	vs := NewVarSet(fs)
+	vs.LookupEnv = func(string) (string, bool) { return "", false }
+	var seen string
+	vs.OnSet("log-level", func(value string) error {
+		seen = value
+		if value != "info" && value != "debug" {
+			return fmt.Errorf("must be info or debug")
+		}
+		return nil
+	})
+	if err := vs.Parse(); err != nil {
+		t.Fatalf("Parse() = %v, want nil", err)
+	}
+	if seen != "info" {
+		t.Errorf("OnSet saw %q, want %q", seen, "info")
+	}
+
+	fs2 := flag.NewFlagSet("", flag.ContinueOnError)
+	fs2.String("log-level", "bogus", "")
+	must(fs2.Parse(nil))
+	vs2 := NewVarSet(fs2)
+	vs2.LookupEnv = func(string) (string, bool) { return "", false }
+	vs2.OnSet("log-level", func(value string) error {
+		if value != "info" && value != "debug" {
+			return fmt.Errorf("must be info or debug")
+		}
+		return nil
+	})
+	if err := vs2.Parse(); err == nil {
+		t.Error("Parse() = nil, want error for invalid log level")
+	}
+}
+
 func must(err error) {
 	if err != nil {
 		panic(err)